@@ -0,0 +1,146 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package vba
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/phaag/go-vba/ole"
+)
+
+// isCFB reports whether magic is the OLE Compound File Binary signature,
+// i.e. the file is a vbaProject.bin or an unmodified legacy .doc/.xls/.ppt
+func isCFB(magic []byte) bool {
+	return len(magic) >= len(ole.Signature) && bytes.Equal(magic[:len(ole.Signature)], ole.Signature)
+}
+
+// sniffedFile bundles a file opened for format detection with the few
+// leading bytes already read from it
+type sniffedFile struct {
+	f     *os.File
+	ra    io.ReaderAt
+	size  int64
+	magic []byte
+}
+
+func (s *sniffedFile)close() error {
+	return s.f.Close()
+}
+
+// openFileForSniff opens inFile and reads its first few bytes so the
+// caller can dispatch on isCFB/isOOXML without consuming the file
+func openFileForSniff(inFile string) (*sniffedFile, error) {
+	f, err := os.Open(inFile)
+	if err != nil {
+		return nil, err
+	}
+
+	finfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	magic := make([]byte, 8)
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+
+	return &sniffedFile{f: f, ra: f, size: finfo.Size(), magic: magic}, nil
+}
+
+// decompressCFB extracts every VBA module stream found in the CFB container
+// ra and writes one decompressed file per module into the directory outDir
+func decompressCFB(ra io.ReaderAt, size int64, outDir string) (bool, error) {
+
+	modules, err := ole.ExtractVBAModules(ra, size)
+	if err != nil {
+		return false, fmt.Errorf("VBA: %v\n", err)
+	}
+
+	return writeModules(modules, outDir)
+
+} // End of decompressCFB
+
+// writeModules decompresses every module stream in modules and writes one
+// .bas file per module into the directory outDir. Streams that do not carry
+// a recognisable VBA signature (e.g. the "dir" or "_VBA_PROJECT" streams)
+// are skipped
+func writeModules(modules map[string]io.Reader, outDir string) (bool, error) {
+
+	if len(modules) == 0 {
+		return false, fmt.Errorf("VBA: no VBA modules found")
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return false, err
+	}
+
+	wrote := false
+	for name, rd := range modules {
+		base := filepath.Base(name)
+		if base == "" || base == "." || base == ".." {
+			// module name carries no usable file name (e.g. a path
+			// traversal attempt from an untrusted document) - skip it
+			continue
+		}
+
+		outFile := filepath.Join(outDir, base+".bas")
+		wfd, err := os.OpenFile(outFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return wrote, err
+		}
+
+		vba := NewWriter(wfd)
+		_, err = io.Copy(vba, rd)
+		vba.Close()
+		wfd.Close()
+
+		finfo, statErr := os.Stat(outFile)
+		if err != nil || statErr != nil || finfo.Size() == 0 {
+			os.Remove(outFile)
+			continue
+		}
+		wrote = true
+	}
+
+	if !wrote {
+		return false, fmt.Errorf("VBA: no module decompressed cleanly")
+	}
+
+	return true, nil
+
+} // End of writeModules
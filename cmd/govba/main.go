@@ -0,0 +1,68 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Command govba decompresses MS-OVBA VBA source, accepting a raw compressed
+// stream, a vbaProject.bin/.doc/.xls/.ppt CFB container or a .docm/.xlsm/.pptm
+// OOXML document as input.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	vba "github.com/phaag/go-vba"
+)
+
+func main() {
+
+	inFile := flag.String("in", "-", "input file (\"-\" for stdin)")
+	outFile := flag.String("out", "-", "output file, or output directory when -in is a CFB or OOXML document")
+	list := flag.Bool("list", false, "list the names of the streams in the VBA storage of -in without decompressing them")
+	flag.Parse()
+
+	if *list {
+		names, err := vba.ListModules(*inFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "govba: %v\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if _, err := vba.DecompressFile(*inFile, *outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "govba: %v\n", err)
+		os.Exit(1)
+	}
+
+} // End of main
@@ -0,0 +1,322 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package vba
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maximum number of hash chain candidates to walk per match search
+const maxChainLen = 32
+
+type VBACompressor struct {
+	wr     io.Writer
+	buffer []byte
+	size   int
+	header bool
+}
+
+func NewCompressor(wr io.Writer) *VBACompressor {
+	vba := new(VBACompressor)
+	vba.wr = wr
+	vba.buffer = make([]byte, 4096)
+	return vba
+}
+
+func (vba *VBACompressor)Write(b []byte) (int, error) {
+	if !vba.header {
+		if _, err := vba.wr.Write([]byte{0x01}); err != nil {
+			return 0, err
+		}
+		vba.header = true
+	}
+
+	offset := 0
+	for offset < len(b) {
+		avail := 4096 - vba.size
+		n := len(b) - offset
+		if n > avail {
+			n = avail
+		}
+		copy(vba.buffer[vba.size:vba.size+n], b[offset:offset+n])
+		vba.size += n
+		offset += n
+
+		if vba.size == 4096 {
+			if err := vba.flushChunk(); err != nil {
+				return offset, err
+			}
+		}
+	}
+
+	return len(b), nil
+}
+
+// flushChunk compresses vba.buffer[:vba.size] and writes the resulting
+// compressed chunk to the underlying writer, then resets the buffer
+func (vba *VBACompressor)flushChunk() error {
+	chunk := vba.buffer[:vba.size]
+	body := compressChunk(chunk)
+
+	var header uint16
+	var payload []byte
+	if vba.size == 4096 && len(body) >= 4096 {
+		// compression did not pay off - fall back to a raw chunk
+		header = 0x3000 | uint16(4098-3)
+		payload = chunk
+	} else {
+		header = 0xB000 | uint16(len(body)+2-3)
+		payload = body
+	}
+
+	var headerBytes [2]byte
+	binary.LittleEndian.PutUint16(headerBytes[:], header)
+	if _, err := vba.wr.Write(headerBytes[:]); err != nil {
+		return err
+	}
+	if _, err := vba.wr.Write(payload); err != nil {
+		return err
+	}
+
+	vba.size = 0
+	return nil
+}
+
+// Close flushes any remaining buffered bytes as one or more final, possibly
+// shorter than 4096 bytes, compressed chunks
+func (vba *VBACompressor)Close() error {
+	if !vba.header {
+		if _, err := vba.wr.Write([]byte{0x01}); err != nil {
+			return err
+		}
+		vba.header = true
+	}
+	if vba.size == 0 {
+		return nil
+	}
+
+	// the final chunk can be shorter than 4096 bytes. Unlike a full chunk,
+	// it cannot be padded out and stored raw without changing the true
+	// decompressed length, so it must always be emitted in the compressed
+	// (token) encoding, splitting it further if its token stream would
+	// not fit the 12-bit chunk size field
+	if err := emitCompressedChunk(vba.wr, vba.buffer[:vba.size]); err != nil {
+		return err
+	}
+
+	vba.size = 0
+	return nil
+}
+
+// emitCompressedChunk compresses data and writes it as one MS-OVBA chunk.
+// If the resulting token stream is too large to fit the chunk header's
+// 12-bit size field, data is split in half and each half is emitted as its
+// own chunk instead
+func emitCompressedChunk(wr io.Writer, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	body := compressChunk(data)
+	if len(body) > 4096 {
+		mid := len(data) / 2
+		if err := emitCompressedChunk(wr, data[:mid]); err != nil {
+			return err
+		}
+		return emitCompressedChunk(wr, data[mid:])
+	}
+
+	var headerBytes [2]byte
+	binary.LittleEndian.PutUint16(headerBytes[:], 0xB000|uint16(len(body)+2-3))
+	if _, err := wr.Write(headerBytes[:]); err != nil {
+		return err
+	}
+	_, err := wr.Write(body)
+	return err
+
+} // End of emitCompressedChunk
+
+// compressChunk encodes up to 4096 bytes of uncompressed data into the
+// MS-OVBA compressed token stream: groups of up to 8 tokens preceded by a
+// flag byte, where each token is either a literal byte or a 2-byte copy
+// token. Matches are searched greedily using a hash chain keyed on the
+// 3-byte prefix starting at each position
+func compressChunk(chunk []byte) []byte {
+
+	n := len(chunk)
+	hashHead := make(map[uint32]int)
+	prev := make([]int, n)
+
+	hashAt := func(i int) uint32 {
+		return uint32(chunk[i]) | uint32(chunk[i+1])<<8 | uint32(chunk[i+2])<<16
+	}
+
+	insert := func(i int) {
+		if i+3 > n {
+			return
+		}
+		h := hashAt(i)
+		if head, ok := hashHead[h]; ok {
+			prev[i] = head
+		} else {
+			prev[i] = -1
+		}
+		hashHead[h] = i
+	}
+
+	var out []byte
+	pos := 0
+	for pos < n {
+		flagPos := len(out)
+		out = append(out, 0)
+		var flag byte
+
+		for bitIndex := uint(0); bitIndex < 8 && pos < n; bitIndex++ {
+			_, _, bitCount, maximumLength := copyTokenHelp(uint(pos), 0)
+			maxOffset := 1 << bitCount
+			matchLen, matchOffset := findLongestMatch(chunk, pos, hashHead, prev, int(maximumLength), maxOffset)
+
+			if matchLen >= 3 {
+				temp2 := 16 - bitCount
+				copyToken := (uint16(matchOffset-1) << temp2) | uint16(matchLen-3)
+				out = append(out, byte(copyToken), byte(copyToken>>8))
+				flag |= 1 << bitIndex
+				for k := 0; k < matchLen; k++ {
+					insert(pos + k)
+				}
+				pos += matchLen
+			} else {
+				out = append(out, chunk[pos])
+				insert(pos)
+				pos++
+			}
+		}
+
+		out[flagPos] = flag
+	}
+
+	return out
+
+} // End of compressChunk
+
+// findLongestMatch walks the hash chain for the 3-byte prefix at pos and
+// returns the length and offset of the longest match found within
+// maxOffset bytes back in chunk, capped at maxLen
+func findLongestMatch(chunk []byte, pos int, hashHead map[uint32]int, prev []int, maxLen int, maxOffset int) (int, int) {
+
+	n := len(chunk)
+	remaining := n - pos
+	if remaining < 3 {
+		return 0, 0
+	}
+	if maxLen > remaining {
+		maxLen = remaining
+	}
+
+	h := uint32(chunk[pos]) | uint32(chunk[pos+1])<<8 | uint32(chunk[pos+2])<<16
+	candidate, ok := hashHead[h]
+	if !ok {
+		return 0, 0
+	}
+
+	bestLen, bestOffset := 0, 0
+	for tries := 0; candidate >= 0 && tries < maxChainLen; tries++ {
+		offset := pos - candidate
+		if offset > maxOffset {
+			break
+		}
+
+		length := 0
+		for length < maxLen && chunk[candidate+length] == chunk[pos+length] {
+			length++
+		}
+		if length > bestLen {
+			bestLen = length
+			bestOffset = offset
+		}
+
+		candidate = prev[candidate]
+	}
+
+	return bestLen, bestOffset
+
+} // End of findLongestMatch
+
+func CompressFile(inFile, outFile string) (bool, error) {
+
+	var rfd, wfd *os.File
+	var err error
+	if inFile == "-" {
+		rfd = os.Stdin
+	} else {
+		if finfo, err := os.Stat(inFile); err != nil {
+			return false, err
+		} else if !finfo.Mode().IsRegular() {
+			return false, fmt.Errorf("%s is not a regualar file", inFile)
+		}
+
+		rfd, err = os.Open(inFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if outFile == "-" {
+		wfd = os.Stdout
+	} else {
+		wfd, err = os.OpenFile(outFile, os.O_RDWR|os.O_CREATE, 0755)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	vba := NewCompressor(wfd)
+	_, err = io.Copy(vba, rfd)
+	if err == nil {
+		err = vba.Close()
+	}
+
+	if inFile != "-" {
+		rfd.Close()
+	}
+	if outFile != "-" {
+		wfd.Close()
+	}
+	if err != nil {
+		return false, fmt.Errorf("VBA: %v\n", err)
+	}
+
+	return true, nil
+
+} // End of CompressFile
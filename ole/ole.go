@@ -0,0 +1,429 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Package ole implements just enough of the OLE2 / Compound File Binary
+// (CFB) format - as used by legacy .doc/.xls/.ppt files and embedded inside
+// .docm/.xlsm/.pptm ZIP archives - to walk the storage/stream directory
+// tree and read out individual streams, such as the VBA module streams
+// found in a vbaProject.bin blob.
+package ole
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Signature is the fixed 8 byte CFB header magic
+var Signature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	headerSize  = 512
+	difatInline = 109 // number of FAT sector entries stored in the header itself
+
+	freeSect   = 0xFFFFFFFF
+	endOfChain = 0xFFFFFFFE
+	fatSect    = 0xFFFFFFFD
+	difSect    = 0xFFFFFFFC
+	noStream   = 0xFFFFFFFF
+
+	objUnknown = 0x00
+	objStorage = 0x01
+	objStream  = 0x02
+	objRoot    = 0x05
+)
+
+// DirEntry is one 128 byte directory entry of the CFB directory stream
+type DirEntry struct {
+	Name          string
+	Type          byte
+	LeftSibling   uint32
+	RightSibling  uint32
+	Child         uint32
+	StartSector   uint32
+	StreamSize    uint64
+}
+
+// Reader holds the parsed structure of a CFB container and gives access to
+// its storages and streams
+type Reader struct {
+	ra             io.ReaderAt
+	size           int64
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint32
+
+	fat         []uint32
+	miniFat     []uint32
+	dirEntries  []DirEntry
+	miniStream  []byte
+}
+
+// Open parses the CFB header, FAT, mini-FAT and directory stream of the
+// container read from ra, which spans size bytes
+func Open(ra io.ReaderAt, size int64) (*Reader, error) {
+
+	header := make([]byte, headerSize)
+	if _, err := ra.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("ole: reading header: %v", err)
+	}
+	if !bytes.Equal(header[0:8], Signature) {
+		return nil, fmt.Errorf("ole: not a CFB container")
+	}
+
+	sectorShift := binary.LittleEndian.Uint16(header[30:32])
+	miniSectorShift := binary.LittleEndian.Uint16(header[32:34])
+	numFatSectors := binary.LittleEndian.Uint32(header[44:48])
+	firstDirSector := binary.LittleEndian.Uint32(header[48:52])
+	miniCutoff := binary.LittleEndian.Uint32(header[56:60])
+	firstMiniFatSector := binary.LittleEndian.Uint32(header[60:64])
+	numMiniFatSectors := binary.LittleEndian.Uint32(header[64:68])
+	firstDifatSector := binary.LittleEndian.Uint32(header[68:72])
+	numDifatSectors := binary.LittleEndian.Uint32(header[72:76])
+
+	r := &Reader{
+		ra:             ra,
+		size:           size,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniSectorShift,
+		miniCutoff:     miniCutoff,
+	}
+
+	// collect the FAT sector locations: the 109 inline entries, followed by
+	// any additional DIFAT sectors
+	fatSectors := make([]uint32, 0, numFatSectors)
+	for i := 0; i < difatInline; i++ {
+		off := 76 + i*4
+		loc := binary.LittleEndian.Uint32(header[off : off+4])
+		if loc != freeSect {
+			fatSectors = append(fatSectors, loc)
+		}
+	}
+
+	sector := firstDifatSector
+	for i := uint32(0); i < numDifatSectors && sector != endOfChain && sector != freeSect; i++ {
+		buf, err := r.readSector(sector)
+		if err != nil {
+			return nil, err
+		}
+		entries := r.sectorSize / 4
+		for j := 0; j < entries-1; j++ {
+			loc := binary.LittleEndian.Uint32(buf[j*4 : j*4+4])
+			if loc != freeSect {
+				fatSectors = append(fatSectors, loc)
+			}
+		}
+		sector = binary.LittleEndian.Uint32(buf[(entries-1)*4 : entries*4])
+	}
+
+	if err := r.readFat(fatSectors); err != nil {
+		return nil, err
+	}
+	if err := r.readMiniFat(firstMiniFatSector, numMiniFatSectors); err != nil {
+		return nil, err
+	}
+	if err := r.readDirectory(firstDirSector); err != nil {
+		return nil, err
+	}
+	if err := r.readMiniStream(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+
+} // End of Open
+
+func (r *Reader)readSector(sector uint32) ([]byte, error) {
+	offset := headerSize + int64(sector)*int64(r.sectorSize)
+	buf := make([]byte, r.sectorSize)
+	if _, err := r.ra.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("ole: reading sector %d: %v", sector, err)
+	}
+	return buf, nil
+}
+
+// readFat concatenates the uint32 entries of every FAT sector into the
+// sector chain table
+func (r *Reader)readFat(fatSectors []uint32) error {
+	entries := r.sectorSize / 4
+	r.fat = make([]uint32, 0, len(fatSectors)*entries)
+	for _, sector := range fatSectors {
+		buf, err := r.readSector(sector)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < entries; i++ {
+			r.fat = append(r.fat, binary.LittleEndian.Uint32(buf[i*4:i*4+4]))
+		}
+	}
+	return nil
+}
+
+// readMiniFat walks the mini-FAT sector chain (itself addressed through the
+// regular FAT) into the mini-FAT table
+func (r *Reader)readMiniFat(first uint32, count uint32) error {
+	entries := r.sectorSize / 4
+	r.miniFat = make([]uint32, 0, int(count)*entries)
+	sector := first
+	for i := uint32(0); i < count && sector != endOfChain && sector != freeSect; i++ {
+		buf, err := r.readSector(sector)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < entries; j++ {
+			r.miniFat = append(r.miniFat, binary.LittleEndian.Uint32(buf[j*4:j*4+4]))
+		}
+		if int(sector) >= len(r.fat) {
+			return fmt.Errorf("ole: mini-FAT sector %d out of range", sector)
+		}
+		sector = r.fat[sector]
+	}
+	return nil
+}
+
+// readChain follows the regular FAT chain starting at sector and returns
+// the concatenated sector data, truncated to size bytes
+func (r *Reader)readChain(sector uint32, size uint64) ([]byte, error) {
+	out := make([]byte, 0, size)
+	seen := make(map[uint32]bool)
+	for sector != endOfChain && sector != freeSect && uint64(len(out)) < size {
+		if seen[sector] {
+			return nil, fmt.Errorf("ole: FAT chain loop at sector %d", sector)
+		}
+		seen[sector] = true
+
+		buf, err := r.readSector(sector)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf...)
+
+		if int(sector) >= len(r.fat) {
+			return nil, fmt.Errorf("ole: FAT sector %d out of range", sector)
+		}
+		sector = r.fat[sector]
+	}
+	if uint64(len(out)) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// readMiniChain follows the mini-FAT chain starting at miniSector and
+// returns the concatenated mini-sector data, truncated to size bytes. It
+// reads from the already assembled mini-stream
+func (r *Reader)readMiniChain(miniSector uint32, size uint64) ([]byte, error) {
+	out := make([]byte, 0, size)
+	seen := make(map[uint32]bool)
+	for miniSector != endOfChain && miniSector != freeSect && uint64(len(out)) < size {
+		if seen[miniSector] {
+			return nil, fmt.Errorf("ole: mini-FAT chain loop at sector %d", miniSector)
+		}
+		seen[miniSector] = true
+
+		start := int64(miniSector) * int64(r.miniSectorSize)
+		end := start + int64(r.miniSectorSize)
+		if end > int64(len(r.miniStream)) {
+			return nil, fmt.Errorf("ole: mini sector %d out of range", miniSector)
+		}
+		out = append(out, r.miniStream[start:end]...)
+
+		if int(miniSector) >= len(r.miniFat) {
+			return nil, fmt.Errorf("ole: mini-FAT sector %d out of range", miniSector)
+		}
+		miniSector = r.miniFat[miniSector]
+	}
+	if uint64(len(out)) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// readDirectory reads every 128 byte directory entry of the directory
+// stream, starting at firstDirSector
+func (r *Reader)readDirectory(firstDirSector uint32) error {
+	// the directory stream length is not known up front - it is simply the
+	// full FAT chain starting at firstDirSector
+	buf, err := r.readChain(firstDirSector, uint64(len(r.fat))*uint64(r.sectorSize))
+	if err != nil {
+		return err
+	}
+
+	count := len(buf) / 128
+	r.dirEntries = make([]DirEntry, count)
+	for i := 0; i < count; i++ {
+		e := buf[i*128 : i*128+128]
+
+		nameLen := binary.LittleEndian.Uint16(e[64:66])
+		if nameLen > 64 {
+			// per MS-CFB the name length in bytes (including the NUL
+			// terminator) never exceeds 64 - clamp a malformed/hostile
+			// entry rather than index past the 128-byte entry below
+			nameLen = 64
+		}
+		name := ""
+		if nameLen >= 2 {
+			u16 := make([]uint16, (nameLen-2)/2)
+			for j := range u16 {
+				u16[j] = binary.LittleEndian.Uint16(e[j*2 : j*2+2])
+			}
+			name = string(utf16.Decode(u16))
+		}
+
+		r.dirEntries[i] = DirEntry{
+			Name:         name,
+			Type:         e[66],
+			LeftSibling:  binary.LittleEndian.Uint32(e[68:72]),
+			RightSibling: binary.LittleEndian.Uint32(e[72:76]),
+			Child:        binary.LittleEndian.Uint32(e[76:80]),
+			StartSector:  binary.LittleEndian.Uint32(e[116:120]),
+			StreamSize:   binary.LittleEndian.Uint64(e[120:128]),
+		}
+	}
+
+	return nil
+}
+
+// readMiniStream reads the root entry's own stream, which backs every
+// mini-FAT sector
+func (r *Reader)readMiniStream() error {
+	if len(r.dirEntries) == 0 {
+		return fmt.Errorf("ole: empty directory")
+	}
+	root := r.dirEntries[0]
+	data, err := r.readChain(root.StartSector, root.StreamSize)
+	if err != nil {
+		return err
+	}
+	r.miniStream = data
+	return nil
+}
+
+// Entries returns every directory entry found in the container
+func (r *Reader)Entries() []DirEntry {
+	return r.dirEntries
+}
+
+// ReadStream returns the raw bytes of the stream described by entry
+func (r *Reader)ReadStream(entry DirEntry) ([]byte, error) {
+	if entry.Type != objStream {
+		return nil, fmt.Errorf("ole: %q is not a stream", entry.Name)
+	}
+	if entry.StreamSize < uint64(r.miniCutoff) {
+		return r.readMiniChain(entry.StartSector, entry.StreamSize)
+	}
+	return r.readChain(entry.StartSector, entry.StreamSize)
+}
+
+// Walk performs a recursive traversal of the storage/stream directory tree
+// starting at the root entry, calling fn with the full "/"-separated path
+// of every entry visited
+func (r *Reader)Walk(fn func(path string, entry DirEntry)) {
+	if len(r.dirEntries) == 0 {
+		return
+	}
+	seen := make(map[uint32]bool)
+	r.walk(r.dirEntries[0].Child, "", fn, seen)
+}
+
+// walk recurses over the red-black sibling tree rooted at id, diving into
+// child storages as it goes. seen guards against a malformed/hostile tree
+// whose sibling or child pointers form a cycle, the same way readChain
+// guards against a looping FAT chain
+func (r *Reader)walk(id uint32, prefix string, fn func(path string, entry DirEntry), seen map[uint32]bool) {
+	if id == noStream || int(id) >= len(r.dirEntries) {
+		return
+	}
+	if seen[id] {
+		return
+	}
+	seen[id] = true
+
+	entry := r.dirEntries[id]
+	r.walk(entry.LeftSibling, prefix, fn, seen)
+
+	path := entry.Name
+	if prefix != "" {
+		path = prefix + "/" + entry.Name
+	}
+	fn(path, entry)
+	if entry.Type == objStorage {
+		r.walk(entry.Child, path, fn, seen)
+	}
+
+	r.walk(entry.RightSibling, prefix, fn, seen)
+}
+
+// ExtractVBAModules opens the CFB container read from ra (spanning size
+// bytes) and returns every stream found inside a "VBA" storage, keyed by
+// its stream name, e.g. "Module1", "ThisDocument", "dir"
+func ExtractVBAModules(ra io.ReaderAt, size int64) (map[string]io.Reader, error) {
+
+	r, err := Open(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]io.Reader)
+	var walkErr error
+	r.Walk(func(path string, entry DirEntry) {
+		if walkErr != nil || entry.Type != objStream {
+			return
+		}
+		if !inVBAStorage(path) {
+			return
+		}
+		data, err := r.ReadStream(entry)
+		if err != nil {
+			walkErr = err
+			return
+		}
+		modules[entry.Name] = bytes.NewReader(data)
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	return modules, nil
+
+} // End of ExtractVBAModules
+
+// inVBAStorage reports whether path names a stream that lives directly
+// inside a storage named "VBA"
+func inVBAStorage(path string) bool {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		return false
+	}
+	return parts[len(parts)-2] == "VBA"
+}
@@ -0,0 +1,147 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package vba
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/phaag/go-vba/ole"
+)
+
+// zipSignature is the local file header magic of a ZIP archive, and
+// therefore of any Office Open XML (.docm/.xlsm/.pptm) document
+var zipSignature = []byte{0x50, 0x4B, 0x03, 0x04}
+
+// isOOXML reports whether magic starts with the ZIP local file header
+// signature, i.e. the file is an Office Open XML package
+func isOOXML(magic []byte) bool {
+	return len(magic) >= len(zipSignature) && bytes.Equal(magic[:len(zipSignature)], zipSignature)
+}
+
+// findVBAProject returns the *zip.File of the vbaProject.bin member embedded
+// in an Office Open XML package, regardless of which part (word/, xl/,
+// ppt/) it lives under
+func findVBAProject(zr *zip.Reader) *zip.File {
+	for _, f := range zr.File {
+		if path.Base(f.Name) == "vbaProject.bin" {
+			return f
+		}
+	}
+	return nil
+}
+
+// ooxmlModules opens inFile as a ZIP archive, locates its vbaProject.bin
+// member and returns the raw VBA module streams found inside it
+func ooxmlModules(inFile string) (map[string]io.Reader, error) {
+
+	zr, err := zip.OpenReader(inFile)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	vbaProject := findVBAProject(&zr.Reader)
+	if vbaProject == nil {
+		return nil, fmt.Errorf("VBA: no vbaProject.bin found in %s", inFile)
+	}
+
+	rc, err := vbaProject.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ole.ExtractVBAModules(bytes.NewReader(data), int64(len(data)))
+
+} // End of ooxmlModules
+
+// DecompressOOXML extracts the vbaProject.bin embedded in the Office Open
+// XML document inFile (.docm, .xlsm, .pptm, ...) and writes one decompressed
+// file per VBA module into the directory outDir
+func DecompressOOXML(inFile, outDir string) error {
+
+	modules, err := ooxmlModules(inFile)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeModules(modules, outDir)
+	return err
+
+} // End of DecompressOOXML
+
+// ListModules reports the names of every stream found in a "VBA" storage of
+// inFile, without decompressing them. Besides the module streams this
+// includes streams such as "dir" and "_VBA_PROJECT", which carry no module
+// source. inFile may be a raw vbaProject.bin, a legacy
+// OLE CFB document (.doc/.xls/.ppt) or an Office Open XML document
+// (.docm/.xlsm/.pptm)
+func ListModules(inFile string) ([]string, error) {
+
+	f, err := openFileForSniff(inFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.close()
+
+	var modules map[string]io.Reader
+	switch {
+	case isCFB(f.magic):
+		modules, err = ole.ExtractVBAModules(f.ra, f.size)
+	case isOOXML(f.magic):
+		modules, err = ooxmlModules(inFile)
+	default:
+		return nil, fmt.Errorf("VBA: %s is neither a CFB nor an OOXML document", inFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+
+} // End of ListModules
@@ -0,0 +1,97 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package vba
+
+import (
+	"io"
+)
+
+// VBAReader implements io.Reader, pulling MS-OVBA compressed bytes from an
+// underlying reader and handing out decompressed bytes to the caller
+type VBAReader struct {
+	rd  io.Reader
+	vba *VBAWriter
+
+	pending []byte
+	readBuf []byte
+	eof     bool
+	err     error
+}
+
+// pendingWriter is the io.Writer the decompressor drains into; it simply
+// appends whatever VBAWriter produces to the pending output queue
+type pendingWriter struct {
+	r *VBAReader
+}
+
+func (w *pendingWriter)Write(b []byte) (int, error) {
+	w.r.pending = append(w.r.pending, b...)
+	return len(b), nil
+}
+
+func NewReader(rd io.Reader) *VBAReader {
+	r := new(VBAReader)
+	r.rd = rd
+	r.vba = NewWriter(&pendingWriter{r: r})
+	r.readBuf = make([]byte, VBABuffSize)
+	return r
+}
+
+func (r *VBAReader)Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		n, err := r.rd.Read(r.readBuf)
+		if n > 0 {
+			if _, werr := r.vba.Write(r.readBuf[:n]); werr != nil {
+				r.err = werr
+				continue
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				r.eof = true
+			} else {
+				r.err = err
+			}
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+
+} // End of Read
@@ -47,8 +47,9 @@ type VBAWriter struct {
 	cSize int
 	uSize int
 	marker struct {
-		cur   int
-		found bool
+		cur    int
+		found  bool
+		header [2]byte
 	}
 }
 
@@ -149,26 +150,50 @@ func (vba *VBAWriter)Write(b []byte) (int, error) {
 }
 
 // carv for VBA signature in stream
-// returns true if signature found 
+// returns true if signature found
+//
+// byte 0 is the 0x01 VBA signature byte (consumed but not stored in
+// compressedBuffer); bytes 1-2 are the first chunk's 2 byte header,
+// stored as-is regardless of their value. What comes after depends on
+// that header: a compressed first chunk carries a flag byte (0x00, an
+// all-literal group) followed by "Attribut", while a raw first chunk -
+// CompressFile's fallback when the leading 4096 bytes do not compress -
+// has no flag byte and the literal text "Attribute" starts right away
 func (vba *VBAWriter)carvVBA(buff []byte) (int, bool) {
 
-    marker	:= []byte("\x01..\x00Attribut")
-	mlen	:= len(marker)
+	const mlen = 12
+	compressedSuffix := []byte("\x00Attribut")
+	rawSuffix := []byte("Attribute")
+	rawHeader := [2]byte{0xff, 0x3f}
 
 	for i, b := range buff {
-		if vba.marker.cur == 1 || vba.marker.cur == 2 {
-			// unconditionally add these 2 bytes
+		if vba.cSize >= len(vba.compressedBuffer) {
+			// marker can never span more bytes than VBABuffSize - bail out
+			// rather than overrun compressedBuffer
+			vba.marker.cur = 0
+			vba.cSize = 0
+		}
+
+		switch {
+		case vba.marker.cur == 0:
+			if b == 0x01 {
+				vba.marker.cur = 1
+			}
+		case vba.marker.cur == 1 || vba.marker.cur == 2:
+			// unconditionally add these 2 header bytes
+			vba.marker.header[vba.marker.cur-1] = b
 			vba.compressedBuffer[vba.cSize] = b
-			vba.marker.cur++
 			vba.cSize++
-		} else {
-			if b == marker[vba.marker.cur] {
+			vba.marker.cur++
+		default:
+			suffix := compressedSuffix
+			if vba.marker.header == rawHeader {
+				suffix = rawSuffix
+			}
+			if b == suffix[vba.marker.cur-3] {
 				vba.compressedBuffer[vba.cSize] = b
+				vba.cSize++
 				vba.marker.cur++
-				if vba.marker.cur != 1 {
-					// skip signature byte \x01 in uncompressed buffer
-					vba.cSize++
-				}
 			} else {
 				vba.marker.cur = 0
 				vba.cSize = 0
@@ -236,10 +261,16 @@ func (vba *VBAWriter)uncompressChunk() (int, error) {
 				if CompressedCurrent < CompressedEnd {
 					flagBit := (flagByte >> bitIndex) & 0x1
 					if flagBit == 0 {
+						if vba.uSize >= len(vba.uncompressedBuffer) {
+							return 0, fmt.Errorf("Uncompress error: uncompressed buffer overrun\n")
+						}
 						vba.uncompressedBuffer[vba.uSize] = vba.compressedBuffer[CompressedCurrent]
 						vba.uSize++
 						CompressedCurrent++
 					} else {
+						if CompressedCurrent+2 > CompressedEnd {
+							return 0, fmt.Errorf("Uncompress error: copy token overruns compressed chunk\n")
+						}
 						var copyToken uint16
 						copyToken = binary.LittleEndian.Uint16(vba.compressedBuffer[CompressedCurrent : CompressedCurrent+2])
 						lengthMask, offsetMask, bitCount, _ := copyTokenHelp(uint(vba.uSize), uint(decompressedChunkStart))
@@ -247,7 +278,13 @@ func (vba *VBAWriter)uncompressChunk() (int, error) {
 						temp1 := copyToken & offsetMask
 						temp2 := 16 - bitCount
 						offset := int((temp1 >> temp2) + 1)
+						if offset > vba.uSize {
+							return 0, fmt.Errorf("Uncompress error: copy token offset %d exceeds decompressed size %d\n", offset, vba.uSize)
+						}
 						copySource := vba.uSize - offset
+						if vba.uSize+length > len(vba.uncompressedBuffer) {
+							return 0, fmt.Errorf("Uncompress error: copy token length %d overruns uncompressed buffer\n", length)
+						}
 						for index := copySource; index < copySource+length; index++ {
 							vba.uncompressedBuffer[vba.uSize] = vba.uncompressedBuffer[index]
 							vba.uSize++
@@ -279,6 +316,23 @@ func DecompressFile(inFile, outFile string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+
+		magic := make([]byte, 8)
+		if _, err := rfd.ReadAt(magic, 0); err == nil {
+			switch {
+			case isCFB(magic):
+				defer rfd.Close()
+				finfo, err := rfd.Stat()
+				if err != nil {
+					return false, err
+				}
+				return decompressCFB(rfd, finfo.Size(), outFile)
+			case isOOXML(magic):
+				rfd.Close()
+				err := DecompressOOXML(inFile, outFile)
+				return err == nil, err
+			}
+		}
 	}
 
 	if outFile == "-" {
@@ -0,0 +1,78 @@
+/*
+ *  Copyright (c) 2020, Peter Haag
+ *  All rights reserved.
+ *
+ *  Redistribution and use in source and binary forms, with or without
+ *  modification, are permitted provided that the following conditions are met:
+ *
+ *   * Redistributions of source code must retain the above copyright notice,
+ *     this list of conditions and the following disclaimer.
+ *   * Redistributions in binary form must reproduce the above copyright notice,
+ *     this list of conditions and the following disclaimer in the documentation
+ *     and/or other materials provided with the distribution.
+ *   * Neither the name of the author nor the names of its contributors may be
+ *     used to endorse or promote products derived from this software without
+ *     specific prior written permission.
+ *
+ *  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ *  AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ *  IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ *  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+ *  LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ *  CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ *  SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ *  INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ *  CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ *  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ *  POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package vba
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// FuzzUncompressChunk feeds arbitrary bytes directly into uncompressChunk,
+// bypassing carvVBA, to make sure a malformed CompressedChunkSize or copy
+// token never panics or reads out of bounds
+func FuzzUncompressChunk(f *testing.F) {
+
+	seed := make([]byte, VBABuffSize)
+	binary.LittleEndian.PutUint16(seed[0:2], 0x3000|uint16(VBABuffSize-3))
+	f.Add(seed)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		vba := NewWriter(io.Discard)
+		vba.cSize = copy(vba.compressedBuffer, data)
+		if vba.cSize < 2 {
+			return
+		}
+		vba.uncompressChunk()
+	})
+
+} // End of FuzzUncompressChunk
+
+// FuzzWrite feeds arbitrary bytes through VBAWriter.Write, exercising the
+// signature carving together with chunk decompression, to make sure no
+// input can trigger a panic, an out of bounds slice access or a hang
+func FuzzWrite(f *testing.F) {
+
+	seedSrc := []byte("Attribute VB_Name = \"Module1\"\nSub Test()\nEnd Sub\n")
+	var seed bytes.Buffer
+	c := NewCompressor(&seed)
+	c.Write(seedSrc)
+	c.Close()
+	f.Add(seed.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		w := NewWriter(io.Discard)
+		w.Write(data)
+		w.Close()
+	})
+
+} // End of FuzzWrite